@@ -19,12 +19,13 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -44,6 +45,11 @@ var (
 
 	svc     server.Service
 	handler http.Handler
+
+	// ready is flipped to 1 once every configured listener (HTTP and, if
+	// enabled, HTTPS) is bound, so a readiness probe hitting us before then
+	// correctly reports not-ready.
+	ready int32
 )
 
 func main() {
@@ -58,11 +64,25 @@ func main() {
 			logger.Log("main", fmt.Sprintf("Using %v as ach.File TTL", achFileTTL))
 		}
 	}
-	r := server.NewRepositoryInMemory(achFileTTL, logger)
+	r, err := repositoryFromEnv(achFileTTL, logger)
+	if err != nil {
+		logger.Log("main", err)
+		os.Exit(1)
+	}
 	svc = server.NewService(r)
 
 	// Create HTTP server
-	handler = server.MakeHTTPHandler(svc, r, log.With(logger, "component", "HTTP"))
+	baseHandler := server.MakeInstrumentedHTTPHandler(server.MakeHTTPHandler(svc, r, log.With(logger, "component", "HTTP")), r, logger)
+	mux := http.NewServeMux()
+	mux.Handle("/", baseHandler)
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&ready) == 1 {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	handler = mux
 
 	// Listen for application termination.
 	errs := make(chan error)
@@ -77,32 +97,98 @@ func main() {
 	idleTimeout, _ := time.ParseDuration("60s")
 
 	serve := &http.Server{
-		Addr:  *httpAddr,
-		Handler: handler,
-		TLSConfig: &tls.Config{
-			InsecureSkipVerify:       false,
-			PreferServerCipherSuites: true,
-			MinVersion:               tls.VersionTLS12,
-		},
+		Addr:         *httpAddr,
+		Handler:      handler,
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writTimeout,
 		IdleTimeout:  idleTimeout,
 	}
+	var serveTLS *http.Server
+
+	httpsCertFile := os.Getenv("HTTPS_CERT_FILE")
+	httpsKeyFile := os.Getenv("HTTPS_KEY_FILE")
+	boundListeners := int32(1) // the plain HTTP listener always binds
+
+	if httpsCertFile != "" && httpsKeyFile != "" {
+		reloader, err := newCertReloader(httpsCertFile, httpsKeyFile, log.With(logger, "component", "TLS"))
+		if err != nil {
+			logger.Log("main", err)
+			os.Exit(1)
+		}
+		tlsConfig, err := buildHTTPSTLSConfig(reloader, os.Getenv("HTTPS_CLIENT_CA_FILE"), os.Getenv("HTTPS_REQUIRE_CLIENT_CERT") == "true")
+		if err != nil {
+			logger.Log("main", err)
+			os.Exit(1)
+		}
+
+		httpsAddr := os.Getenv("HTTPS_ADDR")
+		if httpsAddr == "" {
+			httpsAddr = ":8443"
+		}
+		serveTLS = &http.Server{
+			Addr:         httpsAddr,
+			Handler:      handler,
+			TLSConfig:    tlsConfig,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writTimeout,
+			IdleTimeout:  idleTimeout,
+		}
+		boundListeners++
+	}
+
 	shutdownServer := func() {
 		if err := serve.Shutdown(context.TODO()); err != nil {
 			logger.Log("shutdown", err)
 		}
+		if serveTLS != nil {
+			if err := serveTLS.Shutdown(context.TODO()); err != nil {
+				logger.Log("shutdown", err)
+			}
+		}
+	}
+
+	var bound int32
+	markBound := func() {
+		if atomic.AddInt32(&bound, 1) == boundListeners {
+			atomic.StoreInt32(&ready, 1)
+			logger.Log("startup", "all listeners bound, marking ready")
+		}
 	}
 
 	// Start main HTTP server
+	ln, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		logger.Log("main", err)
+		os.Exit(1)
+	}
 	go func() {
 		logger.Log("startup", fmt.Sprintf("binding to %s for HTTP server", *httpAddr))
-		if err := serve.ListenAndServe(); err != nil {
+		markBound()
+		if err := serve.Serve(ln); err != nil {
 			errs <- err
 			logger.Log("exit", err)
 		}
 	}()
 
+	// Start HTTPS server, when configured
+	if serveTLS != nil {
+		tlsLn, err := net.Listen("tcp", serveTLS.Addr)
+		if err != nil {
+			logger.Log("main", err)
+			os.Exit(1)
+		}
+		go func() {
+			logger.Log("startup", fmt.Sprintf("binding to %s for HTTPS server", serveTLS.Addr))
+			markBound()
+			// cert/key are served from TLSConfig.GetCertificate, so no
+			// filenames are needed here.
+			if err := serveTLS.ServeTLS(tlsLn, "", ""); err != nil {
+				errs <- err
+				logger.Log("exit", err)
+			}
+		}()
+	}
+
 	if err := <-errs; err != nil {
 		shutdownServer()
 		logger.Log("exit", err)
@@ -0,0 +1,82 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/moov-io/ach/server"
+
+	"github.com/go-kit/kit/log"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// repositoryFromEnv builds a server.Repository based on ACH_REPOSITORY_TYPE
+// (one of "memory" (default), "filesystem", "sql", "s3") and the env vars
+// that backend needs. It keeps the in-memory repository as the default so
+// existing deployments don't need any configuration changes.
+func repositoryFromEnv(ttl time.Duration, logger log.Logger) (server.Repository, error) {
+	cfg := server.RepositoryConfig{
+		Type:    os.Getenv("ACH_REPOSITORY_TYPE"),
+		FileTTL: ttl,
+		Logger:  logger,
+	}
+
+	switch cfg.Type {
+	case "filesystem":
+		dir := os.Getenv("ACH_REPOSITORY_DIR")
+		if dir == "" {
+			dir = "./storage"
+		}
+		cfg.Filesystem = server.FilesystemConfig{Dir: dir}
+
+	case "sql":
+		driver := os.Getenv("ACH_REPOSITORY_SQL_DRIVER")
+		if driver == "" {
+			driver = "sqlite3"
+		}
+		dsn := os.Getenv("ACH_REPOSITORY_DSN")
+		if dsn == "" {
+			return nil, fmt.Errorf("ACH_REPOSITORY_DSN is required when ACH_REPOSITORY_TYPE=sql")
+		}
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s database: %v", driver, err)
+		}
+		cfg.SQL = server.SQLConfig{DB: db, Driver: driver}
+
+	case "s3":
+		bucket := os.Getenv("ACH_REPOSITORY_S3_BUCKET")
+		if bucket == "" {
+			return nil, fmt.Errorf("ACH_REPOSITORY_S3_BUCKET is required when ACH_REPOSITORY_TYPE=s3")
+		}
+		cfg.S3 = server.S3Config{
+			Bucket:   bucket,
+			Prefix:   os.Getenv("ACH_REPOSITORY_S3_PREFIX"),
+			Endpoint: os.Getenv("ACH_REPOSITORY_S3_ENDPOINT"),
+			Region:   os.Getenv("ACH_REPOSITORY_S3_REGION"),
+		}
+	}
+
+	return server.NewRepository(cfg)
+}
@@ -0,0 +1,107 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/go-kit/kit/log"
+)
+
+// certReloader keeps an in-memory tls.Certificate up to date by re-reading
+// certFile/keyFile from disk whenever the process receives SIGHUP, without
+// requiring a restart to pick up a renewed certificate.
+type certReloader struct {
+	certFile, keyFile string
+	logger            log.Logger
+
+	cert atomic.Value // holds *tls.Certificate
+}
+
+// newCertReloader loads certFile/keyFile once and returns a certReloader
+// whose GetCertificate is suitable for tls.Config.GetCertificate.
+func newCertReloader(certFile, keyFile string, logger log.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watchSIGHUP()
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading cert=%s key=%s: %v", r.certFile, r.keyFile, err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load().(*tls.Certificate), nil
+}
+
+func (r *certReloader) watchSIGHUP() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		if err := r.reload(); err != nil {
+			r.logger.Log("tls", fmt.Sprintf("problem reloading certificate: %v", err))
+		} else {
+			r.logger.Log("tls", "reloaded TLS certificate")
+		}
+	}
+}
+
+// buildHTTPSTLSConfig assembles the tls.Config used by the HTTPS listener:
+// certificates are served (and hot-reloaded) via reloader.GetCertificate,
+// and client certificate auth (mTLS) is enabled when clientCAFile is set.
+func buildHTTPSTLSConfig(reloader *certReloader, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		GetCertificate:           reloader.GetCertificate,
+		PreferServerCipherSuites: true,
+		MinVersion:               tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA file %s: %v", clientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		if requireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return cfg, nil
+}
@@ -26,48 +26,101 @@ import (
 
 const NACHAFileLineLimit = 10000
 
+// lineCountTolerance is how close our running estimate is allowed to get to
+// NACHAFileLineLimit before we fall back to a full lineCount(outf) to confirm
+// the exact count. baseLineCount/batchLineCount deliberately exclude the
+// all-"9" filler lines Writer.Write pads the file out to (to round the total
+// record count up to a multiple of 10), so an estimate built from them alone
+// can fall short of the real line count by up to 9 lines -- that's what this
+// tolerance guards against, without requiring a full Writer.Write on every
+// batch appended. Once a fallback render has replaced the estimate with an
+// exact (padded) count, later additions can push it above the real count.
+const lineCountTolerance = 10
+
 // MergeFiles is a helper function for consolidating an array of ACH Files into as few files
 // as possible. This is useful for optimizing cost and network efficiency.
 // This operation will override batch numbers in each file to ensure they do not collide. The ascending batch numbers will start at 1.
 //
-// Per NACHA rules files must remain under 10,000 lines (when rendered in their ASCII encoding)
+// Per NACHA rules files must remain under 10,000 lines (when rendered in their ASCII encoding). Callers
+// can tighten this and add further constraints -- a max dollar amount, a max batch count, or a bucketing
+// function run alongside the routing-pair grouping -- via MergeOption, e.g. WithMaxLines, WithMaxDollarAmount,
+// WithMaxBatchCount, and WithBucketBy. MergeFiles(files) with no options enforces only the NACHA line limit.
 //
 // File Batches can only be merged if they are unique and routed to and from the same ABA routing numbers.
-func MergeFiles(files []*File) ([]*File, error) {
-	fs := &mergableFiles{infiles: files}
+func MergeFiles(files []*File, opts ...MergeOption) ([]*File, error) {
+	o := newMergeOptions(opts)
+	fs := &mergableFiles{infiles: files, opts: o}
 	for i := range fs.infiles {
-		outf := fs.lookupByHeader(fs.infiles[i])
 		for j := range fs.infiles[i].Batches {
+			batch := fs.infiles[i].Batches[j]
+			outf := fs.lookupByHeader(fs.infiles[i], batch)
+
 			batchExistsInMerged := false
 			for k := range outf.Batches {
-				if fs.infiles[i].Batches[j].Equal(outf.Batches[k]) {
+				if batch.Equal(outf.Batches[k]) {
 					batchExistsInMerged = true
 				}
 			}
-			if !batchExistsInMerged {
-				outf.AddBatch(fs.infiles[i].Batches[j])
+			if batchExistsInMerged {
+				continue
+			}
+
+			if reason, ok := fs.exceedsConstraints(outf, batch); ok {
+				o.stats.record(reason, outf.Header, *batch.GetHeader())
+
+				f := *outf
+				fs.locMaxed = append(fs.locMaxed, &f)
+
+				outf = fs.create(outf) // replace output file with the one we just created
+				fs.setBucket(outf, fs.bucketKey(batch))
+			}
+
+			added := batchLineCount(batch)
+			estimate := fs.lineEstimate(outf) + added
+
+			n := estimate
+			if estimate+lineCountTolerance >= o.maxLines {
+				// The estimate is close enough to the limit that padding or
+				// rounding could tip it over, so confirm with a real render.
+				outf.AddBatch(batch)
 				if err := outf.Create(); err != nil {
 					return nil, err
 				}
-				n, err := lineCount(outf)
-				if n == 0 || err != nil {
+				// maxLines=0 bypasses lineCount's <100-batch shortcut, which
+				// would otherwise return 1 here instead of the real count.
+				actual, err := lineCount(outf, 0)
+				if actual == 0 || err != nil {
 					return nil, fmt.Errorf("problem getting line count of File (header: %#v): %v", outf.Header, err)
 				}
-				if n > NACHAFileLineLimit {
-					outf.RemoveBatch(fs.infiles[i].Batches[j])
-					if err := outf.Create(); err != nil { // rebalance ACH file after removing the Batch
-						return nil, err
-					}
-					f := *outf
-					fs.locMaxed = append(fs.locMaxed, &f)
-
-					outf = fs.create(outf) // replace output file with the one we just created
-
-					outf.AddBatch(fs.infiles[i].Batches[j])
-					if err := outf.Create(); err != nil {
-						return nil, err
-					}
+				n = actual
+				fs.setLineEstimate(outf, actual)
+			} else {
+				outf.AddBatch(batch)
+				fs.setLineEstimate(outf, estimate)
+			}
+			fs.addDollarAmount(outf, batchDollarAmount(batch))
+
+			if n > o.maxLines {
+				outf.RemoveBatch(batch)
+				if err := outf.Create(); err != nil { // rebalance ACH file after removing the Batch
+					return nil, err
+				}
+				fs.setLineEstimate(outf, fs.lineEstimate(outf)-added)
+				fs.addDollarAmount(outf, -batchDollarAmount(batch))
+
+				f := *outf
+				fs.locMaxed = append(fs.locMaxed, &f)
+
+				o.stats.record(RolloverReasonLines, outf.Header, *batch.GetHeader())
+				outf = fs.create(outf) // replace output file with the one we just created
+				fs.setBucket(outf, fs.bucketKey(batch))
+
+				outf.AddBatch(batch)
+				if err := outf.Create(); err != nil {
+					return nil, err
 				}
+				fs.setLineEstimate(outf, baseLineCount(outf)+added)
+				fs.addDollarAmount(outf, batchDollarAmount(batch))
 			}
 		}
 	}
@@ -90,52 +143,256 @@ type mergableFiles struct {
 	infiles  []*File
 	outfiles []*File
 	locMaxed []*File
+
+	opts *mergeOptions
+
+	// lineCounts holds a running, incrementally maintained line estimate for
+	// each *File held in outfiles so MergeFiles doesn't need to re-render the
+	// whole file (via Writer.Write) after every batch is appended.
+	lineCounts map[*File]int
+
+	// dollarAmounts and buckets track the other per-file constraints
+	// MergeOptions can add: a running total (in cents) of every Batch's
+	// entries, and the WithBucketBy key each outfile was created for.
+	dollarAmounts map[*File]int64
+	buckets       map[*File]string
+
+	// seeded marks the input Files that have already been reused as an
+	// outfile's initial seed in lookupByHeader, so an input File is never
+	// appended to outfiles more than once. Only consulted when WithBucketBy
+	// is not set -- see lookupByHeader.
+	seeded map[*File]bool
 }
 
-// create returns the index of a newly created file in fs.outfiles given the details from f.Header
-func (fs *mergableFiles) create(f *File) *File { // returns the outfiles index of the created file
-	now := time.Now()
+// exceedsConstraints reports whether adding batch to outf would violate the
+// max dollar amount or max batch count constraints, independent of the
+// line-count check MergeFiles performs afterwards (that one needs to know
+// the actual overflowing line count, so it rolls over separately). Bucket
+// mismatches are handled earlier, in lookupByHeader, since outf is only
+// ever returned for a batch whose bucket already matches -- by the time
+// exceedsConstraints runs, outf's bucket is guaranteed to equal batch's.
+func (fs *mergableFiles) exceedsConstraints(outf *File, batch Batcher) (RolloverReason, bool) {
+	if fs.opts.maxBatchCount > 0 && len(outf.Batches) >= fs.opts.maxBatchCount {
+		return RolloverReasonBatchCount, true
+	}
+	if fs.opts.maxDollarAmount > 0 && fs.dollarAmount(outf)+batchDollarAmount(batch) > fs.opts.maxDollarAmount {
+		return RolloverReasonDollarAmount, true
+	}
+	return "", false
+}
 
-	// remove the current file from outfiles
-	for i := range fs.outfiles {
-		if fs.outfiles[i].Header.ImmediateDestination == f.Header.ImmediateDestination &&
-			fs.outfiles[i].Header.ImmediateOrigin == f.Header.ImmediateOrigin {
-			// found a matching file, so remove it from fs.outfiles
-			fs.outfiles = append(fs.outfiles[:i], fs.outfiles[i+1:]...)
-			goto next
-		}
+func (fs *mergableFiles) bucketKey(batch Batcher) string {
+	if fs.opts.bucketBy == nil {
+		return ""
+	}
+	return fs.opts.bucketBy(batch.GetHeader())
+}
+
+func (fs *mergableFiles) setBucket(f *File, key string) {
+	if fs.buckets == nil {
+		fs.buckets = make(map[*File]string)
+	}
+	fs.buckets[f] = key
+}
+
+// dollarAmount returns the running total dollar amount (in cents) across
+// every Batch currently in f, computing it from scratch (and caching it)
+// the first time f is seen -- mirrors lineEstimate, since f can reach here
+// already holding Batches (lookupByHeader reuses an input File as an
+// outfile) that never went through addDollarAmount.
+func (fs *mergableFiles) dollarAmount(f *File) int64 {
+	if fs.dollarAmounts == nil {
+		fs.dollarAmounts = make(map[*File]int64)
+	}
+	if n, ok := fs.dollarAmounts[f]; ok {
+		return n
+	}
+	var n int64
+	for _, b := range f.Batches {
+		n += batchDollarAmount(b)
+	}
+	fs.dollarAmounts[f] = n
+	return n
+}
+
+func (fs *mergableFiles) addDollarAmount(f *File, delta int64) {
+	fs.dollarAmounts[f] = fs.dollarAmount(f) + delta
+}
+
+// baseLineCount returns the fixed overhead (in lines) of a File before any
+// batches are added: the file header and file control records. It does not
+// include the all-"9" filler lines Writer.Write appends to round the file's
+// total record count up to a multiple of 10, so it's a conservative lower
+// bound on the rendered line count, not an exact match for lineCount.
+func baseLineCount(f *File) int {
+	return 2 // FileHeader + FileControl
+}
+
+// batchLineCount estimates the number of lines a Batch will occupy once
+// rendered: the batch header and control records, one line per
+// EntryDetail, and one line per Addenda record attached to each entry.
+// Like baseLineCount, it excludes the file's trailing filler lines, so it's
+// a conservative lower bound on the actual rendered line count.
+func batchLineCount(b Batcher) int {
+	entries := b.GetEntries()
+	lines := 2 // BatchHeader + BatchControl
+	for _, e := range entries {
+		lines++ // EntryDetail
+		lines += entryAddendaCount(e)
+	}
+	return lines
+}
+
+// entryAddendaCount sums the Addenda records attached to e across every
+// concrete Addenda field EntryDetail exposes (EntryDetail has no single
+// unified Addenda slice). It does not account for IAT's Addenda10-18
+// records, which live on IATEntryDetail, a distinct type this function
+// never sees -- batchLineCount (and therefore MergeFiles' line estimate)
+// is only known to be accurate for Batches built from EntryDetail.
+func entryAddendaCount(e *EntryDetail) int {
+	n := len(e.Addenda05)
+	if e.Addenda02 != nil {
+		n++
+	}
+	if e.Addenda98 != nil {
+		n++
+	}
+	if e.Addenda99 != nil {
+		n++
 	}
-next:
+	return n
+}
+
+// roundUpTo10 rounds n up to the next multiple of 10, matching how
+// Writer.Write pads a File's total record count with filler lines.
+func roundUpTo10(n int) int {
+	if n%10 == 0 {
+		return n
+	}
+	return n + (10 - n%10)
+}
+
+// lineEstimate returns the running line count estimate for f, computing it
+// from scratch (and caching it) the first time f is seen.
+func (fs *mergableFiles) lineEstimate(f *File) int {
+	if fs.lineCounts == nil {
+		fs.lineCounts = make(map[*File]int)
+	}
+	if n, ok := fs.lineCounts[f]; ok {
+		return n
+	}
+	n := baseLineCount(f)
+	for _, b := range f.Batches {
+		n += batchLineCount(b)
+	}
+	fs.lineCounts[f] = n
+	return n
+}
+
+// setLineEstimate records the running line count estimate for f.
+func (fs *mergableFiles) setLineEstimate(f *File, n int) {
+	if fs.lineCounts == nil {
+		fs.lineCounts = make(map[*File]int)
+	}
+	fs.lineCounts[f] = n
+}
+
+// newBlankFile returns a newly initialized File seeded with f's Header (and
+// a refreshed creation date/time), with no Batches of its own. Callers are
+// responsible for adding it to fs.outfiles.
+func newBlankFile(f *File) *File {
+	now := time.Now()
 	out := NewFile()
 	out.Header = f.Header
 	out.Header.FileCreationDate = now.Format("060102") // YYMMDD
 	out.Header.FileCreationTime = now.Format("1504")   // HHmm
 	out.Create()
-	fs.outfiles = append(fs.outfiles, out) // add the new outfile
+	return out
+}
 
+// create replaces f in fs.outfiles with a new, empty File built from f.Header and returns it
+func (fs *mergableFiles) create(f *File) *File { // returns the newly created outfile
+	// remove the current file from outfiles
+	for i := range fs.outfiles {
+		if fs.outfiles[i] == f {
+			// found f itself, so remove it from fs.outfiles
+			fs.outfiles = append(fs.outfiles[:i], fs.outfiles[i+1:]...)
+			break
+		}
+	}
+	out := newBlankFile(f)
+	fs.outfiles = append(fs.outfiles, out) // add the new outfile
 	return out
 }
 
-// lookupByHeader optionally returns a File from fs.files if the FileHeaders match.
+// lookupByHeader optionally returns a File from fs.outfiles if the FileHeaders match and, when
+// WithBucketBy is set, batch's bucket key matches the key the candidate File was created for.
 // This is done because we append batches into files to minimize the count of output files.
 //
 // lookupByHeader will return the existing file (stored in outfiles) if no matching file exists.
-func (fs *mergableFiles) lookupByHeader(f *File) *File {
+func (fs *mergableFiles) lookupByHeader(f *File, batch Batcher) *File {
+	key := fs.bucketKey(batch)
+	var bucketMismatch *File
 	for i := range fs.outfiles {
 		if fs.outfiles[i].Header.ImmediateDestination == f.Header.ImmediateDestination &&
 			fs.outfiles[i].Header.ImmediateOrigin == f.Header.ImmediateOrigin {
-			// found a matching file, so return it
-			return fs.outfiles[i]
+			if fs.opts.bucketBy == nil || fs.buckets[fs.outfiles[i]] == key {
+				// found a matching file, so return it
+				return fs.outfiles[i]
+			}
+			// Same routing pair, but it was built for a different bucket --
+			// keep looking in case another outfile already covers this one.
+			bucketMismatch = fs.outfiles[i]
+		}
+	}
+	if bucketMismatch != nil {
+		fs.opts.stats.record(RolloverReasonBucketMismatch, bucketMismatch.Header, *batch.GetHeader())
+	}
+
+	// No outfile matches this header/bucket yet. With WithBucketBy unset,
+	// the first time an input File is seen, reuse it as-is for the new
+	// outfile: its own pre-existing Batches already count as merged (see
+	// the batchExistsInMerged check in MergeFiles), so nothing further
+	// needs to be added for them. Later input Files sharing the same
+	// routing pair match that outfile in the loop above and have their
+	// Batches merged into it.
+	//
+	// This reuse can't be extended to the bucketed case: f's Batches may
+	// span more than one bucket, and f can only seed one outfile, so
+	// reusing it for a later, different bucket would both duplicate f in
+	// the returned output and drag its other Batches (meant for the first
+	// bucket) into the new one. So whenever WithBucketBy is set, every
+	// outfile -- including the one for the first bucket seen in f -- is
+	// built fresh via newBlankFile.
+	if fs.opts.bucketBy == nil && !fs.seeded[f] {
+		if fs.seeded == nil {
+			fs.seeded = make(map[*File]bool)
 		}
+		fs.seeded[f] = true
+		fs.outfiles = append(fs.outfiles, f)
+		fs.setBucket(f, key)
+		return f
 	}
-	fs.outfiles = append(fs.outfiles, f)
-	return f
+
+	out := newBlankFile(f)
+	fs.outfiles = append(fs.outfiles, out)
+	fs.setBucket(out, key)
+	return out
 }
 
-func lineCount(f *File) (int, error) {
-	if len(f.Batches) < 100 {
+// lineCount renders f in full and counts its non-blank lines. It's only
+// used as a fallback when the incremental estimate in mergableFiles gets
+// close to maxLines, since Writer.Write is costly to call for every batch
+// appended to a large file.
+func lineCount(f *File, maxLines int) (int, error) {
+	if len(f.Batches) < 100 && maxLines >= NACHAFileLineLimit {
 		// Ignore Files with low batch counts by returning a valid count.
-		// Calling Writer.Write() is costly and so we're going to ignore it in easy cases.
+		// Calling Writer.Write() is costly and so we're going to ignore it
+		// in easy cases. This shortcut only holds at the full NACHA line
+		// limit: with a WithMaxLines value well below NACHAFileLineLimit,
+		// a single batch can approach the limit long before 100 batches
+		// accumulate, so any configured limit smaller than the default
+		// always falls through to a real render below.
 		return 1, nil
 	}
 
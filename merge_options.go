@@ -0,0 +1,132 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ach
+
+// mergeOptions holds the constraints MergeFiles enforces in addition to the
+// NACHA line limit. newMergeOptions with no MergeOption enforces only
+// NACHAFileLineLimit, which keeps MergeFiles(files) backward compatible with
+// callers that pass no MergeOption.
+type mergeOptions struct {
+	maxLines        int
+	maxDollarAmount int64 // cents; 0 means unlimited
+	maxBatchCount   int   // 0 means unlimited
+	bucketBy        func(*BatchHeader) string
+
+	stats *MergeStats
+}
+
+func newMergeOptions(opts []MergeOption) *mergeOptions {
+	o := &mergeOptions{
+		maxLines: NACHAFileLineLimit,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// MergeOption configures the constraints MergeFiles splits output files on.
+type MergeOption func(*mergeOptions)
+
+// WithMaxLines overrides the default NACHAFileLineLimit (10,000) used to
+// decide when a File must be split.
+func WithMaxLines(n int) MergeOption {
+	return func(o *mergeOptions) {
+		o.maxLines = n
+	}
+}
+
+// WithMaxDollarAmount caps the total debit+credit Amount (in cents) a merged
+// File can contain before a new File is started.
+func WithMaxDollarAmount(cents int64) MergeOption {
+	return func(o *mergeOptions) {
+		o.maxDollarAmount = cents
+	}
+}
+
+// WithMaxBatchCount caps the number of Batches a merged File can contain
+// before a new File is started.
+func WithMaxBatchCount(n int) MergeOption {
+	return func(o *mergeOptions) {
+		o.maxBatchCount = n
+	}
+}
+
+// WithBucketBy partitions Batches into separate output Files whenever fn
+// returns a different key, in addition to the routing-pair grouping
+// MergeFiles already does. A common use is bucketing by EffectiveEntryDate
+// so batches with different settlement dates never share a File.
+func WithBucketBy(fn func(*BatchHeader) string) MergeOption {
+	return func(o *mergeOptions) {
+		o.bucketBy = fn
+	}
+}
+
+// WithMergeStats populates stats with a MergeRollover record each time
+// MergeFiles starts a new output File, explaining which constraint forced
+// the split.
+func WithMergeStats(stats *MergeStats) MergeOption {
+	return func(o *mergeOptions) {
+		o.stats = stats
+	}
+}
+
+// RolloverReason identifies which constraint caused MergeFiles to start a
+// new output File.
+type RolloverReason string
+
+const (
+	RolloverReasonLines          RolloverReason = "lines"
+	RolloverReasonDollarAmount   RolloverReason = "dollar_amount"
+	RolloverReasonBatchCount     RolloverReason = "batch_count"
+	RolloverReasonBucketMismatch RolloverReason = "bucket_mismatch"
+)
+
+// MergeRollover records a single instance of MergeFiles starting a new
+// output File because an existing one could not accept the next Batch.
+type MergeRollover struct {
+	Reason      RolloverReason
+	FileHeader  FileHeader
+	BatchHeader BatchHeader
+}
+
+// MergeStats reports why MergeFiles produced the output Files it did, for
+// callers that want to log or alert on unexpected rollover volume. Pass it
+// in via WithMergeStats.
+type MergeStats struct {
+	Rollovers []MergeRollover
+}
+
+func (s *MergeStats) record(reason RolloverReason, fh FileHeader, bh BatchHeader) {
+	if s == nil {
+		return
+	}
+	s.Rollovers = append(s.Rollovers, MergeRollover{
+		Reason:      reason,
+		FileHeader:  fh,
+		BatchHeader: bh,
+	})
+}
+
+func batchDollarAmount(b Batcher) int64 {
+	var total int64
+	for _, e := range b.GetEntries() {
+		total += int64(e.Amount)
+	}
+	return total
+}
@@ -0,0 +1,179 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// metrics holds every counter/histogram the HTTP handler records. It's a
+// package-level singleton (like the rest of the server package's wiring)
+// since there's only ever one Prometheus registry per process.
+//
+// FilesCreated, FilesDeleted, EntriesPerFile, and ValidationFailures are fed
+// by metricsRepository (see repository_metrics.go).
+//
+// There is no files_merged or merge_rollovers counter: neither this file nor
+// metricsRepository observes ach.MergeFiles calls.
+var metrics = struct {
+	FilesCreated       kitprometheus.Counter
+	FilesDeleted       kitprometheus.Counter
+	EntriesPerFile     kitprometheus.Histogram
+	ValidationFailures kitprometheus.Counter // labeled by "field"
+	HTTPLatency        kitprometheus.Histogram
+}{
+	FilesCreated: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "ach",
+		Name:      "files_created",
+		Help:      "Count of ACH files created on the server",
+	}, nil),
+	FilesDeleted: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "ach",
+		Name:      "files_deleted",
+		Help:      "Count of ACH files deleted on the server",
+	}, nil),
+	EntriesPerFile: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "ach",
+		Name:      "entries_per_file",
+		Help:      "Distribution of EntryDetail counts across files uploaded to the server",
+		Buckets:   stdprometheus.ExponentialBuckets(1, 4, 10),
+	}, nil),
+	ValidationFailures: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "ach",
+		Name:      "validation_failures",
+		Help:      "Count of FieldError validation failures, labeled by field name",
+	}, []string{"field"}),
+	HTTPLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "ach",
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, labeled by route and status",
+		Buckets:   stdprometheus.DefBuckets,
+	}, []string{"route", "status"}),
+}
+
+// recordValidationFailure increments ValidationFailures for a single
+// ach.FieldError, broken out by FieldName so dashboards can show which
+// fields reject the most uploads.
+func recordValidationFailure(fieldName string) {
+	metrics.ValidationFailures.With("field", fieldName).Add(1)
+}
+
+// MakeInstrumentedHTTPHandler wraps next (normally the result of
+// MakeHTTPHandler) with Prometheus latency instrumentation per route,
+// mounts /metrics in Prometheus text format, and registers the streaming
+// export routes from stream.go -- since MakeHTTPHandler's own router isn't
+// exposed for other packages to add routes to, this is where they're wired
+// in so GET /files/{id}/entries.ndjson|.csv actually resolve. It also stamps
+// every request with a request_id/trace_id (see traceRequests) before any
+// route sees it, but that only reaches log lines this package itself
+// writes -- see traceRequests' doc comment for the gap on next's side.
+func MakeInstrumentedHTTPHandler(next http.Handler, repo Repository, logger log.Logger) http.Handler {
+	r := mux.NewRouter()
+	r.Path("/metrics").Handler(promhttp.Handler())
+	registerStreamRoutes(r, repo, logger)
+	r.PathPrefix("/").Handler(instrumentHTTPHandler(next))
+	return traceRequests(r)
+}
+
+// traceRequests stamps r's context with a request_id and trace_id (see
+// saveRequestTracingIntoContext) before handing the request to next, so
+// routes registered on this package's router -- currently the streaming
+// export handlers in stream.go -- can pull both back out via traceLogger.
+// MakeHTTPHandler's routes also receive the stamped context, but they run
+// behind next as an opaque http.Handler and log through their own logger,
+// not traceLogger: request/trace IDs are only guaranteed on the log lines
+// of the routes registered here, not MakeHTTPHandler's.
+func traceRequests(next http.Handler) http.Handler {
+	withTracing := saveRequestTracingIntoContext()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withTracing(r.Context(), r)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// instrumentHTTPHandler records HTTPLatency for every request that reaches
+// next, labeled by response status code and a low-cardinality route label
+// (see routeLabel), not the raw request path.
+func instrumentHTTPHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		metrics.HTTPLatency.With(
+			"route", routeLabel(r),
+			"status", strconv.Itoa(sw.status),
+		).Observe(time.Since(started).Seconds())
+	})
+}
+
+// routeLabel returns a low-cardinality label for r's path, so HTTPLatency's
+// "route" label doesn't grow one series per file/batch ID ever requested.
+// When r was matched by a route registered directly on this package's
+// router (true for the streaming export routes in stream.go), the route's
+// own path template is exact and used as-is. Otherwise r reached us via the
+// PathPrefix("/") catch-all in front of MakeHTTPHandler's own router, whose
+// route table isn't exposed to this package, so collapseDynamicIDs is used
+// to approximate a template from the path's shape instead.
+func routeLabel(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil && tmpl != "/" {
+			return tmpl
+		}
+	}
+	return collapseDynamicIDs(r.URL.Path)
+}
+
+// collapseDynamicIDs replaces the dynamic ID segments this server's API
+// embeds in most routes (/files/{fileID}, /files/{fileID}/batches/{batchID},
+// ...) with placeholders, given just the request path.
+func collapseDynamicIDs(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(segments); i++ {
+		switch segments[i-1] {
+		case "files":
+			segments[i] = "{fileID}"
+		case "batches":
+			segments[i] = "{batchID}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// statusRecorder captures the status code written by the wrapped handler so
+// instrumentHTTPHandler can label HTTPLatency by it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
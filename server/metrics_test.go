@@ -0,0 +1,36 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import "testing"
+
+func TestCollapseDynamicIDs(t *testing.T) {
+	cases := map[string]string{
+		"/files/abc123":                "/files/{fileID}",
+		"/files/abc123/batches":        "/files/{fileID}/batches",
+		"/files/abc123/batches/def456": "/files/{fileID}/batches/{batchID}",
+		"/files/abc123/entries.ndjson": "/files/{fileID}/entries.ndjson",
+		"/metrics":                     "/metrics",
+		"/ping":                        "/ping",
+	}
+	for path, want := range cases {
+		if got := collapseDynamicIDs(path); got != want {
+			t.Errorf("collapseDynamicIDs(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/moov-io/ach"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Repository is the storage interface used by Service to persist and
+// retrieve ACH files uploaded to this server. Implementations must enforce
+// the same TTL semantics as NewRepositoryInMemory: a File not accessed or
+// refreshed within its TTL is eligible for removal, either proactively via
+// a background reaper or lazily at read time.
+type Repository interface {
+	StoreFile(f *ach.File) error
+	FindFile(id string) (*ach.File, error)
+	FindAllFiles() []*ach.File
+	DeleteFile(id string) error
+
+	StoreBatch(fileId string, b ach.Batcher) error
+	DeleteBatch(fileId, batchId string) error
+}
+
+// RepositoryConfig controls which Repository implementation NewRepository
+// returns and how it's configured. Exactly one of the *Config fields should
+// be set; unset fields are ignored.
+type RepositoryConfig struct {
+	Type string // one of: "memory" (default), "filesystem", "sql", "s3"
+
+	FileTTL time.Duration
+	Logger  log.Logger
+
+	Filesystem FilesystemConfig
+	SQL        SQLConfig
+	S3         S3Config
+}
+
+// NewRepository returns the Repository implementation selected by cfg.Type,
+// defaulting to the in-memory repository when Type is empty or "memory".
+// The returned Repository is wrapped so its calls feed the package's
+// Prometheus metrics (see metricsRepository).
+func NewRepository(cfg RepositoryConfig) (Repository, error) {
+	repo, err := newRepository(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newMetricsRepository(repo), nil
+}
+
+func newRepository(cfg RepositoryConfig) (Repository, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return NewRepositoryInMemory(cfg.FileTTL, cfg.Logger), nil
+
+	case "filesystem":
+		return NewRepositoryFilesystem(cfg.Filesystem.Dir, cfg.FileTTL, cfg.Logger)
+
+	case "sql":
+		return NewRepositorySQL(cfg.SQL, cfg.FileTTL, cfg.Logger)
+
+	case "s3":
+		return NewRepositoryS3(cfg.S3, cfg.FileTTL, cfg.Logger)
+
+	default:
+		return nil, fmt.Errorf("server: unknown repository type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,223 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/moov-io/ach"
+
+	"github.com/go-kit/kit/log"
+)
+
+// FilesystemConfig configures NewRepositoryFilesystem.
+type FilesystemConfig struct {
+	// Dir is the directory each File is stored under. It's created if it
+	// doesn't already exist.
+	Dir string
+}
+
+// fileMetadata is the sidecar JSON stored next to each NACHA-encoded file so
+// TTL expiry can be computed without re-parsing the ACH file itself.
+type fileMetadata struct {
+	ID         string    `json:"id"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// filesystemRepository persists each File as its NACHA-encoded plaintext
+// (<Dir>/<id>.ach) plus a sidecar metadata file (<Dir>/<id>.json) used to
+// track upload time for TTL expiry. It's a reasonable choice for a single
+// instance of the server that needs files to survive a restart but doesn't
+// need them shared across replicas.
+type filesystemRepository struct {
+	dir    string
+	ttl    time.Duration
+	logger log.Logger
+
+	mu sync.Mutex // guards concurrent writes to the same file
+}
+
+// NewRepositoryFilesystem returns a Repository that stores each ach.File as
+// plaintext under dir, alongside a JSON sidecar of metadata used for TTL
+// expiry. dir is created (including parents) if it doesn't exist.
+func NewRepositoryFilesystem(dir string, ttl time.Duration, logger log.Logger) (Repository, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("filesystem repository: creating %s: %v", dir, err)
+	}
+	repo := &filesystemRepository{
+		dir:    dir,
+		ttl:    ttl,
+		logger: logger,
+	}
+	if ttl > 0 {
+		go repo.reap()
+	}
+	return repo, nil
+}
+
+func (r *filesystemRepository) filePath(id string) string     { return filepath.Join(r.dir, id+".ach") }
+func (r *filesystemRepository) metadataPath(id string) string { return filepath.Join(r.dir, id+".json") }
+
+func (r *filesystemRepository) StoreFile(f *ach.File) error {
+	if f == nil || f.ID == "" {
+		return fmt.Errorf("filesystem repository: missing File.ID")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(r.dir, f.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := ach.NewWriter(tmp).Write(f); err != nil {
+		tmp.Close()
+		return fmt.Errorf("filesystem repository: writing %s: %v", f.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), r.filePath(f.ID)); err != nil {
+		return err
+	}
+
+	meta := fileMetadata{ID: f.ID, UploadedAt: time.Now()}
+	bs, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.metadataPath(f.ID), bs, 0640)
+}
+
+func (r *filesystemRepository) FindFile(id string) (*ach.File, error) {
+	fd, err := os.Open(r.filePath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer fd.Close()
+
+	f, err := ach.NewReader(fd).Read()
+	if err != nil {
+		return nil, fmt.Errorf("filesystem repository: reading %s: %v", id, err)
+	}
+	f.ID = id
+	return &f, nil
+}
+
+func (r *filesystemRepository) FindAllFiles() []*ach.File {
+	entries, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil
+	}
+	var out []*ach.File
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".ach" {
+			continue
+		}
+		id := e.Name()[:len(e.Name())-len(".ach")]
+		if f, err := r.FindFile(id); err == nil && f != nil {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (r *filesystemRepository) DeleteFile(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.Remove(r.filePath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(r.metadataPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *filesystemRepository) StoreBatch(fileId string, b ach.Batcher) error {
+	f, err := r.FindFile(fileId)
+	if err != nil || f == nil {
+		return fmt.Errorf("filesystem repository: file %s not found", fileId)
+	}
+	f.AddBatch(b)
+	if err := f.Create(); err != nil {
+		return err
+	}
+	return r.StoreFile(f)
+}
+
+func (r *filesystemRepository) DeleteBatch(fileId, batchId string) error {
+	f, err := r.FindFile(fileId)
+	if err != nil || f == nil {
+		return fmt.Errorf("filesystem repository: file %s not found", fileId)
+	}
+	for _, b := range f.Batches {
+		if b.ID() == batchId {
+			f.RemoveBatch(b)
+			if err := f.Create(); err != nil {
+				return err
+			}
+			return r.StoreFile(f)
+		}
+	}
+	return nil
+}
+
+// reap periodically removes files whose sidecar metadata shows they're
+// older than r.ttl, mirroring the in-memory repository's TTL behavior.
+func (r *filesystemRepository) reap() {
+	ticker := time.NewTicker(r.ttl / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		entries, err := ioutil.ReadDir(r.dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) != ".json" {
+				continue
+			}
+			bs, err := ioutil.ReadFile(filepath.Join(r.dir, e.Name()))
+			if err != nil {
+				continue
+			}
+			var meta fileMetadata
+			if err := json.Unmarshal(bs, &meta); err != nil {
+				continue
+			}
+			if time.Since(meta.UploadedAt) > r.ttl {
+				if err := r.DeleteFile(meta.ID); err != nil {
+					r.logger.Log("filesystem-repository", fmt.Sprintf("reaping %s: %v", meta.ID, err))
+				}
+			}
+		}
+	}
+}
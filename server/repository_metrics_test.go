@@ -0,0 +1,62 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/moov-io/ach"
+)
+
+// stubRepository is a Repository whose methods return whatever error each
+// test case configures, so metricsRepository can be exercised without a
+// real backend.
+type stubRepository struct {
+	Repository
+	storeFileErr  error
+	deleteFileErr error
+	storeBatchErr error
+}
+
+func (s *stubRepository) StoreFile(f *ach.File) error               { return s.storeFileErr }
+func (s *stubRepository) DeleteFile(id string) error                { return s.deleteFileErr }
+func (s *stubRepository) StoreBatch(id string, b ach.Batcher) error { return s.storeBatchErr }
+
+func TestMetricsRepository__storeFilePropagatesFieldError(t *testing.T) {
+	repo := newMetricsRepository(&stubRepository{
+		storeFileErr: &ach.FieldError{FieldName: "TransactionCode", Msg: "invalid"},
+	})
+
+	f := ach.NewFile()
+	if err := repo.StoreFile(f); err == nil {
+		t.Fatal("expected the stub's FieldError to propagate")
+	}
+}
+
+func TestMetricsRepository__deleteFilePropagatesError(t *testing.T) {
+	repo := newMetricsRepository(&stubRepository{deleteFileErr: fmt.Errorf("boom")})
+	if err := repo.DeleteFile("abc123"); err == nil {
+		t.Fatal("expected the stub's error to propagate")
+	}
+
+	repo = newMetricsRepository(&stubRepository{})
+	if err := repo.DeleteFile("abc123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
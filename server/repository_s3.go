@@ -0,0 +1,236 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/moov-io/ach"
+
+	"github.com/go-kit/kit/log"
+)
+
+// S3Config configures NewRepositoryS3. Endpoint is optional and, when set,
+// lets this repository run against an S3-compatible store like MinIO
+// instead of AWS.
+type S3Config struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string // optional, e.g. for MinIO
+	Region   string
+}
+
+// s3Repository persists each File as an object under Prefix/<id>.ach in
+// Bucket. TTL is enforced by a background reaper goroutine that lists
+// objects under Prefix and deletes any whose LastModified is older than
+// ttl, mirroring the filesystem repository's reaper (S3 has no sidecar
+// metadata file to stamp an upload time onto, but ListObjectsV2 already
+// returns LastModified for free, and StoreFile always rewrites the whole
+// object, so LastModified is equivalent to the filesystem repository's
+// UploadedAt).
+type s3Repository struct {
+	bucket string
+	prefix string
+
+	client     *s3.S3
+	uploader   *s3manager.Uploader
+	downloader *s3manager.Downloader
+
+	ttl    time.Duration
+	logger log.Logger
+}
+
+// NewRepositoryS3 returns a Repository backed by the given S3 (or
+// S3-compatible, e.g. MinIO) bucket.
+func NewRepositoryS3(cfg S3Config, ttl time.Duration, logger log.Logger) (Repository, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 repository: bucket is required")
+	}
+
+	awsCfg := aws.NewConfig().WithRegion(cfg.Region)
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("s3 repository: creating session: %v", err)
+	}
+
+	repo := &s3Repository{
+		bucket:     cfg.Bucket,
+		prefix:     strings.Trim(cfg.Prefix, "/"),
+		client:     s3.New(sess),
+		uploader:   s3manager.NewUploader(sess),
+		downloader: s3manager.NewDownloader(sess),
+		ttl:        ttl,
+		logger:     logger,
+	}
+	if ttl > 0 {
+		go repo.reap()
+	}
+	return repo, nil
+}
+
+func (r *s3Repository) key(id string) string {
+	if r.prefix == "" {
+		return id + ".ach"
+	}
+	return r.prefix + "/" + id + ".ach"
+}
+
+func (r *s3Repository) StoreFile(f *ach.File) error {
+	if f == nil || f.ID == "" {
+		return fmt.Errorf("s3 repository: missing File.ID")
+	}
+	var buf bytes.Buffer
+	if err := ach.NewWriter(&buf).Write(f); err != nil {
+		return fmt.Errorf("s3 repository: encoding %s: %v", f.ID, err)
+	}
+
+	_, err := r.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key(f.ID)),
+		Body:   bytes.NewReader(buf.Bytes()),
+	})
+	return err
+}
+
+func (r *s3Repository) FindFile(id string) (*ach.File, error) {
+	buf := aws.NewWriteAtBuffer(nil)
+	_, err := r.downloader.Download(buf, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key(id)),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), s3.ErrCodeNoSuchKey) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	f, err := ach.NewReader(bytes.NewReader(buf.Bytes())).Read()
+	if err != nil {
+		return nil, fmt.Errorf("s3 repository: decoding %s: %v", id, err)
+	}
+	f.ID = id
+	return &f, nil
+}
+
+func (r *s3Repository) FindAllFiles() []*ach.File {
+	var out []*ach.File
+	err := r.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(r.bucket),
+		Prefix: aws.String(r.prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			if !strings.HasSuffix(key, ".ach") {
+				continue
+			}
+			name := key[strings.LastIndex(key, "/")+1:]
+			id := strings.TrimSuffix(name, ".ach")
+			if f, err := r.FindFile(id); err == nil && f != nil {
+				out = append(out, f)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		r.logger.Log("s3-repository", fmt.Sprintf("listing bucket %s: %v", r.bucket, err))
+		return nil
+	}
+	return out
+}
+
+func (r *s3Repository) DeleteFile(id string) error {
+	_, err := r.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(r.key(id)),
+	})
+	return err
+}
+
+func (r *s3Repository) StoreBatch(fileId string, b ach.Batcher) error {
+	f, err := r.FindFile(fileId)
+	if err != nil || f == nil {
+		return fmt.Errorf("s3 repository: file %s not found", fileId)
+	}
+	f.AddBatch(b)
+	if err := f.Create(); err != nil {
+		return err
+	}
+	return r.StoreFile(f)
+}
+
+func (r *s3Repository) DeleteBatch(fileId, batchId string) error {
+	f, err := r.FindFile(fileId)
+	if err != nil || f == nil {
+		return fmt.Errorf("s3 repository: file %s not found", fileId)
+	}
+	for _, b := range f.Batches {
+		if b.ID() == batchId {
+			f.RemoveBatch(b)
+			if err := f.Create(); err != nil {
+				return err
+			}
+			return r.StoreFile(f)
+		}
+	}
+	return nil
+}
+
+// reap periodically removes objects under r.prefix whose LastModified is
+// older than r.ttl, mirroring the filesystem repository's reaper.
+func (r *s3Repository) reap() {
+	ticker := time.NewTicker(r.ttl / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := r.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+			Bucket: aws.String(r.bucket),
+			Prefix: aws.String(r.prefix),
+		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				key := aws.StringValue(obj.Key)
+				if !strings.HasSuffix(key, ".ach") {
+					continue
+				}
+				if time.Since(aws.TimeValue(obj.LastModified)) > r.ttl {
+					if _, err := r.client.DeleteObject(&s3.DeleteObjectInput{
+						Bucket: aws.String(r.bucket),
+						Key:    aws.String(key),
+					}); err != nil {
+						r.logger.Log("s3-repository", fmt.Sprintf("reaping %s: %v", key, err))
+					}
+				}
+			}
+			return true
+		})
+		if err != nil {
+			r.logger.Log("s3-repository", fmt.Sprintf("listing bucket %s for reaping: %v", r.bucket, err))
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/moov-io/ach"
+
+	"github.com/go-kit/kit/log"
+)
+
+// rebind rewrites query's "?" placeholders into Postgres's "$1, $2, ..."
+// form when driver is "postgres"; every other driver (sqlite3) uses "?"
+// natively, so query is returned unchanged.
+func rebind(driver, query string) string {
+	if driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// SQLConfig configures NewRepositorySQL. DB must already be opened against
+// the desired driver ("sqlite3" or "postgres"); this package only manages
+// schema migrations and queries against it.
+type SQLConfig struct {
+	DB     *sql.DB
+	Driver string // "sqlite3" or "postgres"
+}
+
+// sqlMigrations creates the files/batches/entries tables used by
+// sqlRepository. Each statement is safe to re-run (IF NOT EXISTS), so
+// NewRepositorySQL can be called on every process startup.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS ach_files (
+		file_id TEXT PRIMARY KEY,
+		contents BYTEA NOT NULL,
+		uploaded_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP
+	);`,
+	`CREATE TABLE IF NOT EXISTS ach_batches (
+		file_id TEXT NOT NULL,
+		batch_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (file_id, batch_id)
+	);`,
+	`CREATE TABLE IF NOT EXISTS ach_entries (
+		file_id TEXT NOT NULL,
+		batch_id TEXT NOT NULL,
+		trace_number TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (file_id, batch_id, trace_number)
+	);`,
+}
+
+// sqlRepository persists Files as their NACHA-encoded bytes in a SQL table,
+// with TTL enforced by an expires_at column rather than a reaper goroutine
+// so horizontally-scaled instances don't race to clean up the same rows.
+type sqlRepository struct {
+	db     *sql.DB
+	driver string
+	ttl    time.Duration
+	logger log.Logger
+}
+
+// NewRepositorySQL opens (and migrates) a Repository backed by cfg.DB. It
+// works against SQLite and Postgres; both support the subset of SQL used in
+// sqlMigrations, and "?" placeholders in every query below are rebound to
+// Postgres's "$1, $2, ..." form via rebind when cfg.Driver is "postgres".
+func NewRepositorySQL(cfg SQLConfig, ttl time.Duration, logger log.Logger) (Repository, error) {
+	if cfg.DB == nil {
+		return nil, fmt.Errorf("sql repository: no *sql.DB provided")
+	}
+	driver := cfg.Driver
+	if driver == "" {
+		driver = "sqlite3"
+	}
+	for _, stmt := range sqlMigrations {
+		if _, err := cfg.DB.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("sql repository: migrating schema: %v", err)
+		}
+	}
+	return &sqlRepository{db: cfg.DB, driver: driver, ttl: ttl, logger: logger}, nil
+}
+
+func (r *sqlRepository) StoreFile(f *ach.File) error {
+	if f == nil || f.ID == "" {
+		return fmt.Errorf("sql repository: missing File.ID")
+	}
+	var buf bytes.Buffer
+	if err := ach.NewWriter(&buf).Write(f); err != nil {
+		return fmt.Errorf("sql repository: encoding %s: %v", f.ID, err)
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if r.ttl > 0 {
+		exp := now.Add(r.ttl)
+		expiresAt = &exp
+	}
+
+	_, err := r.db.Exec(rebind(r.driver, `INSERT INTO ach_files (file_id, contents, uploaded_at, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (file_id) DO UPDATE SET contents = excluded.contents, uploaded_at = excluded.uploaded_at, expires_at = excluded.expires_at`),
+		f.ID, buf.Bytes(), now, expiresAt)
+	return err
+}
+
+func (r *sqlRepository) FindFile(id string) (*ach.File, error) {
+	row := r.db.QueryRow(rebind(r.driver, `SELECT contents FROM ach_files WHERE file_id = ? AND (expires_at IS NULL OR expires_at > ?)`), id, time.Now())
+
+	var contents []byte
+	if err := row.Scan(&contents); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	f, err := ach.NewReader(bytes.NewReader(contents)).Read()
+	if err != nil {
+		return nil, fmt.Errorf("sql repository: decoding %s: %v", id, err)
+	}
+	f.ID = id
+	return &f, nil
+}
+
+func (r *sqlRepository) FindAllFiles() []*ach.File {
+	rows, err := r.db.Query(rebind(r.driver, `SELECT file_id FROM ach_files WHERE expires_at IS NULL OR expires_at > ?`), time.Now())
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var out []*ach.File
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		if f, err := r.FindFile(id); err == nil && f != nil {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (r *sqlRepository) DeleteFile(id string) error {
+	_, err := r.db.Exec(rebind(r.driver, `DELETE FROM ach_files WHERE file_id = ?`), id)
+	return err
+}
+
+func (r *sqlRepository) StoreBatch(fileId string, b ach.Batcher) error {
+	f, err := r.FindFile(fileId)
+	if err != nil || f == nil {
+		return fmt.Errorf("sql repository: file %s not found", fileId)
+	}
+	f.AddBatch(b)
+	if err := f.Create(); err != nil {
+		return err
+	}
+	if err := r.StoreFile(f); err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := r.db.Exec(rebind(r.driver, `INSERT INTO ach_batches (file_id, batch_id, created_at) VALUES (?, ?, ?)`),
+		fileId, b.ID(), now); err != nil {
+		return err
+	}
+	return r.storeEntries(fileId, b.ID(), b.GetEntries(), now)
+}
+
+// storeEntries records one ach_entries row per EntryDetail, keyed by its
+// TraceNumber, so a trace number can be looked up back to its file and
+// batch without decoding every File's NACHA contents.
+func (r *sqlRepository) storeEntries(fileId, batchId string, entries []*ach.EntryDetail, createdAt time.Time) error {
+	for _, e := range entries {
+		_, err := r.db.Exec(rebind(r.driver, `INSERT INTO ach_entries (file_id, batch_id, trace_number, created_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (file_id, batch_id, trace_number) DO UPDATE SET created_at = excluded.created_at`),
+			fileId, batchId, e.TraceNumber, createdAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *sqlRepository) DeleteBatch(fileId, batchId string) error {
+	f, err := r.FindFile(fileId)
+	if err != nil || f == nil {
+		return fmt.Errorf("sql repository: file %s not found", fileId)
+	}
+	for _, b := range f.Batches {
+		if b.ID() == batchId {
+			f.RemoveBatch(b)
+			if err := f.Create(); err != nil {
+				return err
+			}
+			if err := r.StoreFile(f); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if _, err := r.db.Exec(rebind(r.driver, `DELETE FROM ach_batches WHERE file_id = ? AND batch_id = ?`), fileId, batchId); err != nil {
+		return err
+	}
+	_, err = r.db.Exec(rebind(r.driver, `DELETE FROM ach_entries WHERE file_id = ? AND batch_id = ?`), fileId, batchId)
+	return err
+}
@@ -0,0 +1,71 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// requestIDKey and traceIDKey are the contextKey values saveRequestTracingIntoContext
+// stores onto each request's context, alongside the CORS headers
+// saveCORSHeadersIntoContext already saves there.
+const (
+	requestIDKey contextKey = "request_id"
+	traceIDKey   contextKey = "trace_id"
+)
+
+// saveRequestTracingIntoContext is a go-kit httptransport.RequestFunc that
+// stamps every inbound request with a request_id, generated fresh per call,
+// and a trace_id, reused from the inbound X-Trace-ID header when the caller
+// (e.g. an upstream proxy forwarding CORS headers) already supplied one.
+// Downstream handlers and log lines pull both back out of ctx so operators
+// can correlate a request across log lines, alongside the CORS headers
+// saveCORSHeadersIntoContext already tracks.
+//
+// traceRequests (metrics.go) applies this to every request MakeInstrumented
+// HTTPHandler sees, and streamEntriesHandler logs through traceLogger so its
+// error line carries both IDs. That's the full reach of this tracing:
+// MakeHTTPHandler's handlers log through their own logger, not traceLogger,
+// so their log lines don't carry request_id/trace_id. Request/trace IDs are
+// therefore only guaranteed on the streaming export handlers' log lines,
+// not on MakeHTTPHandler's.
+func saveRequestTracingIntoContext() func(ctx context.Context, r *http.Request) context.Context {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		traceID := r.Header.Get("X-Trace-ID")
+		if traceID == "" {
+			traceID = uuid.New().String()
+		}
+		ctx = context.WithValue(ctx, requestIDKey, uuid.New().String())
+		ctx = context.WithValue(ctx, traceIDKey, traceID)
+		return ctx
+	}
+}
+
+// traceLogger returns logger with request_id and trace_id fields populated
+// from ctx, for handlers to log through so every line from a single request
+// can be grepped together.
+func traceLogger(ctx context.Context, logger kitlog.Logger) kitlog.Logger {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	traceID, _ := ctx.Value(traceIDKey).(string)
+	return kitlog.With(logger, "request_id", requestID, "trace_id", traceID)
+}
@@ -0,0 +1,62 @@
+// Licensed to The Moov Authors under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. The Moov Authors licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+func TestRouting__requestTracing(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ping", nil)
+
+	ctx := saveRequestTracingIntoContext()(context.TODO(), r)
+
+	requestID, ok := ctx.Value(requestIDKey).(string)
+	if !ok || requestID == "" {
+		t.Errorf("expected a generated request_id, got %q", requestID)
+	}
+	traceID, ok := ctx.Value(traceIDKey).(string)
+	if !ok || traceID == "" {
+		t.Errorf("expected a generated trace_id, got %q", traceID)
+	}
+}
+
+func TestRouting__requestTracingReusesTraceID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ping", nil)
+	r.Header.Set("X-Trace-ID", "upstream-trace-id")
+
+	ctx := saveRequestTracingIntoContext()(context.TODO(), r)
+
+	if traceID, _ := ctx.Value(traceIDKey).(string); traceID != "upstream-trace-id" {
+		t.Errorf("expected trace_id to be reused from header, got %q", traceID)
+	}
+}
+
+func TestRouting__traceLogger(t *testing.T) {
+	ctx := context.WithValue(context.TODO(), requestIDKey, "req-1")
+	ctx = context.WithValue(ctx, traceIDKey, "trace-1")
+
+	logger := traceLogger(ctx, kitlog.NewNopLogger())
+	if logger == nil {
+		t.Fatal("expected a non-nil logger")
+	}
+}